@@ -18,15 +18,252 @@ package installers
 
 import (
 	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/gravitational/trace"
 
 	"github.com/gravitational/teleport/api/types"
 )
 
-//go:embed installer.sh.tmpl
-var defaultInstallScript string
+// Arch is the CPU architecture of the target host.
+type Arch string
+
+const (
+	ArchAMD64 Arch = "amd64"
+	ArchARM64 Arch = "arm64"
+)
+
+// Distro is the Linux distribution family of the target host, which
+// determines the package manager a template uses.
+type Distro string
+
+const (
+	// DistroDEB covers Debian/Ubuntu-family distros (apt/dpkg).
+	DistroDEB Distro = "deb"
+	// DistroRPM covers RHEL/Amazon Linux-family distros (yum/rpm).
+	DistroRPM Distro = "rpm"
+)
+
+// CloudProvider is the cloud the target host runs in, which determines the
+// IMDS endpoint a template queries for join credentials.
+type CloudProvider string
+
+const (
+	CloudAWS   CloudProvider = "aws"
+	CloudGCP   CloudProvider = "gcp"
+	CloudAzure CloudProvider = "azure"
+	// CloudNone means bare-metal/on-prem/unknown; templateName falls back
+	// to the generic template for this case.
+	CloudNone CloudProvider = ""
+)
+
+// JoinMethod is how the installed agent proves its identity to join the
+// cluster.
+type JoinMethod string
 
-var DefaultInstaller = types.NewInstallerV1(defaultInstallScript)
+const (
+	JoinMethodToken JoinMethod = "token"
+	JoinMethodIAM   JoinMethod = "iam"
+	JoinMethodGCP   JoinMethod = "gcp"
+	JoinMethodAzure JoinMethod = "azure"
+)
+
+// Repo selects which package repository a rendered script installs from.
+type Repo string
 
+const (
+	RepoStable     Repo = "stable"
+	RepoCloud      Repo = "cloud"
+	RepoEnterprise Repo = "enterprise"
+)
+
+// InstallerTemplate is the set of parameters a named installer script
+// template is rendered with.
 type InstallerTemplate struct {
-	AuthServer string
-}
\ No newline at end of file
+	AuthServer    string
+	Arch          Arch
+	Distro        Distro
+	CloudProvider CloudProvider
+	JoinMethod    JoinMethod
+	Repo          Repo
+}
+
+//go:embed templates/generic.sh.tmpl
+var genericScript string
+
+//go:embed templates/aws-amd64-deb.sh.tmpl
+var awsAMD64DebScript string
+
+//go:embed templates/aws-arm64-deb.sh.tmpl
+var awsARM64DebScript string
+
+//go:embed templates/gcp-amd64-rpm.sh.tmpl
+var gcpAMD64RpmScript string
+
+//go:embed templates/gcp-arm64-rpm.sh.tmpl
+var gcpARM64RpmScript string
+
+//go:embed templates/azure-amd64-deb.sh.tmpl
+var azureAMD64DebScript string
+
+// genericTemplateName is the fallback used when no cloud/arch/distro
+// combination has a more specific registered template.
+const genericTemplateName = "generic"
+
+var (
+	templatesMu sync.Mutex
+	// templates maps a template name (e.g. "aws-amd64-deb", as used by
+	// `tctl create installer.yaml` and returned by SelectTemplate) to its
+	// raw, unrendered script body.
+	templates = map[string]string{
+		genericTemplateName: genericScript,
+		"aws-amd64-deb":     awsAMD64DebScript,
+		"aws-arm64-deb":     awsARM64DebScript,
+		"gcp-amd64-rpm":     gcpAMD64RpmScript,
+		"gcp-arm64-rpm":     gcpARM64RpmScript,
+		"azure-amd64-deb":   azureAMD64DebScript,
+	}
+)
+
+// DefaultInstaller is served when the discovery service can't infer a
+// cloud/arch/distro for a target host.
+var DefaultInstaller = types.NewInstallerV1(genericScript)
+
+// RegisterTemplate adds or overrides a named template, e.g. from `tctl
+// create installer.yaml`.
+func RegisterTemplate(name, script string) {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	templates[name] = script
+}
+
+// TemplateNames returns the names of every registered template, for `tctl
+// installers lint` to iterate over.
+func TemplateNames() []string {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	return names
+}
+
+// templateName derives the registry key for a cloud/arch/distro
+// combination, e.g. CloudAWS/ArchAMD64/DistroDEB -> "aws-amd64-deb".
+func templateName(cloud CloudProvider, arch Arch, distro Distro) string {
+	if cloud == CloudNone {
+		return genericTemplateName
+	}
+	return fmt.Sprintf("%s-%s-%s", cloud, arch, distro)
+}
+
+// SelectTemplate picks the best-matching registered template for a host
+// with the given cloud/arch/distro, falling back to the generic template
+// if nothing more specific is registered.
+func SelectTemplate(cloud CloudProvider, arch Arch, distro Distro) (name, script string) {
+	name = templateName(cloud, arch, distro)
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	if script, ok := templates[name]; ok {
+		return name, script
+	}
+	return genericTemplateName, templates[genericTemplateName]
+}
+
+// ValidateTemplate dry-runs rawTemplate against a representative
+// InstallerTemplate, catching malformed template syntax or references to
+// fields InstallerTemplate doesn't have before the template is saved via
+// `tctl create installer.yaml`.
+func ValidateTemplate(rawTemplate string) error {
+	tmpl, err := template.New("installer").Option("missingkey=error").Parse(rawTemplate)
+	if err != nil {
+		return trace.Wrap(err, "invalid installer template")
+	}
+
+	testParams := InstallerTemplate{
+		AuthServer:    "auth.example.com:3025",
+		Arch:          ArchAMD64,
+		Distro:        DistroDEB,
+		CloudProvider: CloudAWS,
+		JoinMethod:    JoinMethodIAM,
+		Repo:          RepoStable,
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, testParams); err != nil {
+		return trace.Wrap(err, "installer template failed to render against test parameters")
+	}
+	return nil
+}
+
+// curlFailFlags are the curl flag spellings that make it exit non-zero (and
+// stop the script, given `set -e`) on an HTTP error response instead of
+// writing the error page to stdout/the output file as if it were legitimate
+// content.
+var curlFailFlags = []string{"-f", "--fail", "-fsSL", "-fsS", "-sf", "-sfL", "-Lf"}
+
+// joinContinuations collapses backslash-newline shell line continuations so
+// a single logical statement that spans multiple source lines (a common
+// style in these templates for long curl invocations) is checked as one.
+func joinContinuations(script string) []string {
+	var logical []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimRight(line, " \t")
+		if strings.HasSuffix(trimmed, "\\") {
+			current.WriteString(strings.TrimSuffix(trimmed, "\\"))
+			current.WriteByte(' ')
+			continue
+		}
+		current.WriteString(line)
+		logical = append(logical, current.String())
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		logical = append(logical, current.String())
+	}
+	return logical
+}
+
+func hasCurlFailFlag(statement string) bool {
+	for _, field := range strings.Fields(statement) {
+		field = strings.Trim(field, `"'`)
+		for _, flag := range curlFailFlags {
+			if field == flag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lint runs a minimal set of static checks on a rendered installer script.
+// It's not a shellcheck replacement (shellcheck may not be installed on
+// the auth server, and rendered scripts are arbitrary admin-provided
+// shell), but it catches the mistakes most likely to turn an installer
+// into a fleet-wide outage. Checks are evaluated per logical statement
+// (line continuations joined) so one safe curl elsewhere in the script
+// can't silence a warning about a different, unsafe one.
+func Lint(rendered string) []string {
+	var problems []string
+
+	if !strings.Contains(rendered, "set -e") {
+		problems = append(problems, "script does not `set -e`; a failed step partway through would be silently ignored")
+	}
+
+	for _, statement := range joinContinuations(rendered) {
+		if strings.Contains(statement, "curl ") && !hasCurlFailFlag(statement) {
+			problems = append(problems, fmt.Sprintf("curl invocation missing -f/--fail: %q", strings.TrimSpace(statement)))
+		}
+		if (strings.Contains(statement, "| bash") || strings.Contains(statement, "|bash")) && !hasCurlFailFlag(statement) {
+			problems = append(problems, fmt.Sprintf("piping to bash from a curl without -f/--fail: %q", strings.TrimSpace(statement)))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateName(t *testing.T) {
+	require.Equal(t, genericTemplateName, templateName(CloudNone, ArchAMD64, DistroDEB))
+	require.Equal(t, "aws-amd64-deb", templateName(CloudAWS, ArchAMD64, DistroDEB))
+	require.Equal(t, "gcp-arm64-rpm", templateName(CloudGCP, ArchARM64, DistroRPM))
+}
+
+func TestSelectTemplate(t *testing.T) {
+	name, script := SelectTemplate(CloudAWS, ArchAMD64, DistroDEB)
+	require.Equal(t, "aws-amd64-deb", name)
+	require.Equal(t, templates["aws-amd64-deb"], script)
+
+	// No registered template for this combination: falls back to generic.
+	name, script = SelectTemplate(CloudAzure, ArchARM64, DistroRPM)
+	require.Equal(t, genericTemplateName, name)
+	require.Equal(t, templates[genericTemplateName], script)
+}
+
+func TestValidateTemplate(t *testing.T) {
+	require.NoError(t, ValidateTemplate(genericScript))
+
+	for name, script := range templates {
+		require.NoErrorf(t, ValidateTemplate(script), "template %q failed to validate", name)
+	}
+
+	require.Error(t, ValidateTemplate("{{.NotAField}}"))
+	require.Error(t, ValidateTemplate("{{if}}"))
+}
+
+func TestLintSetE(t *testing.T) {
+	require.Empty(t, Lint("#!/bin/bash\nset -euo pipefail\necho hi\n"))
+
+	problems := Lint("#!/bin/bash\necho hi\n")
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0], "set -e")
+}
+
+func TestLintCurlMissingFailFlag(t *testing.T) {
+	problems := Lint("curl https://example.com/install.sh -o /tmp/install.sh\nset -e\n")
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0], "missing -f/--fail")
+}
+
+// TestLintChecksEachCurlIndependently guards against the bug where a single
+// safe curl anywhere in the script silenced the warning for a different,
+// unsafe curl elsewhere in the same script: strings.Contains over the whole
+// rendered body can't tell the two invocations apart.
+func TestLintChecksEachCurlIndependently(t *testing.T) {
+	script := "set -e\n" +
+		"curl -fsSL https://example.com/safe.sh -o /tmp/safe.sh\n" +
+		"curl https://example.com/unsafe.sh -o /tmp/unsafe.sh\n"
+
+	problems := Lint(script)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0], "unsafe.sh")
+}
+
+func TestLintCurlFailFlagAcrossLineContinuation(t *testing.T) {
+	script := "set -e\n" +
+		"curl -fsSL https://example.com/safe.sh \\\n" +
+		"  -o /tmp/safe.sh\n"
+	require.Empty(t, Lint(script))
+}
+
+func TestLintPipeToBashMissingFailFlag(t *testing.T) {
+	problems := Lint("set -e\ncurl https://example.com/install.sh | bash\n")
+	// The same unsafe curl trips both the general missing-fail-flag check
+	// and the pipe-to-bash check.
+	require.Len(t, problems, 2)
+	require.Contains(t, problems[1], "piping to bash")
+}
+
+func TestLintPipeToBashWithFailFlagIsClean(t *testing.T) {
+	require.Empty(t, Lint("set -e\ncurl -fsSL https://example.com/install.sh | bash\n"))
+}
+
+func TestLintAllRegisteredTemplatesAreClean(t *testing.T) {
+	for name, script := range templates {
+		require.Emptyf(t, Lint(script), "template %q has lint problems", name)
+	}
+}
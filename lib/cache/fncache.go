@@ -0,0 +1,202 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// FnCacheConfig configures a fnCache instance.
+type FnCacheConfig struct {
+	// TTL is how long a successfully loaded value is served before a Get
+	// triggers a reload.
+	TTL time.Duration
+	// NegativeTTL is how long a failed load's error is cached before the
+	// next Get retries, rather than serving the cached error. Defaults to
+	// TTL if unset, but callers fetching from a flaky upstream (e.g. an
+	// auth server that occasionally errors) should set this much shorter
+	// than TTL so a transient error isn't mistaken for a stable one.
+	NegativeTTL time.Duration
+}
+
+// fnCache is a generic helper for caching the result of an arbitrary
+// function, coalescing concurrent loads for the same key so that only one
+// caller actually invokes the loader at a time.
+type fnCache struct {
+	cfg FnCacheConfig
+
+	mu      sync.Mutex
+	entries map[interface{}]*fnCacheEntry
+
+	inflightOnce sync.Once
+	inflightSem  chan struct{}
+}
+
+// fnCacheEntry holds the last loaded value for a single key, plus the
+// in-flight load (if any) currently refreshing it.
+type fnCacheEntry struct {
+	mu       sync.Mutex
+	hasValue bool
+	value    interface{}
+	err      error
+	expires  time.Time
+	loading  chan struct{}
+}
+
+// GetOptions tune how a single Get call tolerates staleness.
+type GetOptions struct {
+	// StaleOK, if nonzero, allows Get to return a value that expired up to
+	// StaleOK ago, immediately, while triggering a background reload.
+	StaleOK time.Duration
+	// RefreshAhead, if nonzero, causes Get to kick off a background reload
+	// whenever the cached value is within RefreshAhead of expiring, while
+	// still returning the (still valid) current value synchronously.
+	RefreshAhead time.Duration
+	// MaxInflight, if nonzero, caps the number of distinct loader calls
+	// that may run concurrently across the whole cache. The cap takes
+	// effect from the first Get call that sets it; later calls may not
+	// shrink or grow it.
+	MaxInflight int
+}
+
+// newFnCache creates a fnCache whose entries are valid for ttl, with
+// failed loads cached for the same ttl.
+func newFnCache(ttl time.Duration) *fnCache {
+	return newFnCacheWithConfig(FnCacheConfig{TTL: ttl})
+}
+
+// newFnCacheWithConfig creates a fnCache with independent positive/negative
+// TTLs.
+func newFnCacheWithConfig(cfg FnCacheConfig) *fnCache {
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = cfg.TTL
+	}
+	return &fnCache{
+		cfg:     cfg,
+		entries: make(map[interface{}]*fnCacheEntry),
+	}
+}
+
+// Get loads the value associated with key, calling loadfn at most once
+// across all concurrent callers requesting the same key, and reusing the
+// result until it expires.
+func (c *fnCache) Get(ctx context.Context, key interface{}, loadfn func() (interface{}, error)) (interface{}, error) {
+	return c.GetWithOptions(ctx, key, loadfn, GetOptions{})
+}
+
+// GetWithOptions is Get with stale-while-revalidate / refresh-ahead /
+// inflight-capping behavior controlled by opts.
+func (c *fnCache) GetWithOptions(ctx context.Context, key interface{}, loadfn func() (interface{}, error), opts GetOptions) (interface{}, error) {
+	if opts.MaxInflight > 0 {
+		c.inflightOnce.Do(func() {
+			c.inflightSem = make(chan struct{}, opts.MaxInflight)
+		})
+	}
+
+	entry := c.getOrCreateEntry(key)
+
+	entry.mu.Lock()
+	now := time.Now()
+	stillValid := entry.hasValue && now.Before(entry.expires)
+	withinStale := !stillValid && entry.hasValue && opts.StaleOK > 0 && now.Before(entry.expires.Add(opts.StaleOK))
+
+	if stillValid {
+		if opts.RefreshAhead > 0 && entry.loading == nil && now.After(entry.expires.Add(-opts.RefreshAhead)) {
+			c.startLoad(entry, loadfn)
+		}
+		value, err := entry.value, entry.err
+		entry.mu.Unlock()
+		return value, err
+	}
+
+	if withinStale {
+		if entry.loading == nil {
+			c.startLoad(entry, loadfn)
+		}
+		value, err := entry.value, entry.err
+		entry.mu.Unlock()
+		return value, err
+	}
+
+	var loading chan struct{}
+	if entry.loading != nil {
+		loading = entry.loading
+	} else {
+		loading = c.startLoad(entry, loadfn)
+	}
+	entry.mu.Unlock()
+
+	select {
+	case <-loading:
+		entry.mu.Lock()
+		value, err := entry.value, entry.err
+		entry.mu.Unlock()
+		return value, err
+	case <-ctx.Done():
+		return nil, trace.Wrap(ctx.Err())
+	}
+}
+
+// startLoad starts a load for entry in the background and returns a
+// channel that's closed once it completes. The caller must hold entry.mu
+// when calling startLoad; the spawned goroutine acquires it only after
+// loadfn returns, so unlocking promptly after startLoad is what lets other
+// callers either observe the in-flight load or serve a stale/valid value.
+func (c *fnCache) startLoad(entry *fnCacheEntry, loadfn func() (interface{}, error)) chan struct{} {
+	done := make(chan struct{})
+	entry.loading = done
+
+	go func() {
+		if c.inflightSem != nil {
+			c.inflightSem <- struct{}{}
+			defer func() { <-c.inflightSem }()
+		}
+
+		value, err := loadfn()
+
+		entry.mu.Lock()
+		entry.hasValue = true
+		entry.value = value
+		entry.err = err
+		if err != nil {
+			entry.expires = time.Now().Add(c.cfg.NegativeTTL)
+		} else {
+			entry.expires = time.Now().Add(c.cfg.TTL)
+		}
+		entry.loading = nil
+		entry.mu.Unlock()
+
+		close(done)
+	}()
+
+	return done
+}
+
+func (c *fnCache) getOrCreateEntry(key interface{}) *fnCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &fnCacheEntry{}
+		c.entries[key] = entry
+	}
+	return entry
+}
@@ -158,3 +158,145 @@ func TestFnCacheCancellation(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "val", v.(string))
 }
+
+// TestFnCacheStaleOK verifies that once an entry's ttl has expired, a Get
+// within the StaleOK window returns the stale value immediately instead of
+// blocking on a reload, and that the reload happens in the background.
+func TestFnCacheStaleOK(t *testing.T) {
+	cache := newFnCache(time.Millisecond * 20)
+
+	ctx := context.Background()
+
+	loads := atomic.NewInt64(0)
+	unblockReload := make(chan struct{})
+
+	load := func() (interface{}, error) {
+		n := loads.Inc()
+		if n > 1 {
+			<-unblockReload
+		}
+		return n, nil
+	}
+
+	v, err := cache.Get(ctx, "key", load)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), v.(int64))
+
+	// wait for the entry to expire.
+	time.Sleep(time.Millisecond * 30)
+
+	// within the StaleOK window, Get must return the old value right away
+	// even though a reload (blocked on unblockReload) is in flight.
+	start := time.Now()
+	v, err = cache.GetWithOptions(ctx, "key", load, GetOptions{StaleOK: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), v.(int64), "stale value should be served without waiting for reload")
+	require.Less(t, time.Since(start), time.Millisecond*20)
+
+	close(unblockReload)
+}
+
+// TestFnCacheRefreshAhead verifies that a Get within RefreshAhead of expiry
+// still returns the current value, while triggering a background refresh
+// that's visible on the next Get once it completes.
+func TestFnCacheRefreshAhead(t *testing.T) {
+	cache := newFnCache(time.Millisecond * 40)
+
+	ctx := context.Background()
+
+	loads := atomic.NewInt64(0)
+	load := func() (interface{}, error) {
+		return loads.Inc(), nil
+	}
+
+	opts := GetOptions{RefreshAhead: time.Millisecond * 35}
+
+	v, err := cache.GetWithOptions(ctx, "key", load, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), v.(int64))
+
+	// the refresh-ahead window opens 5ms after the load (40ms ttl minus 35ms
+	// RefreshAhead); a Get inside it triggers a background reload but still
+	// returns the current value synchronously.
+	time.Sleep(time.Millisecond * 15)
+	v, err = cache.GetWithOptions(ctx, "key", load, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), v.(int64))
+
+	require.Eventually(t, func() bool {
+		return loads.Load() == 2
+	}, time.Second, time.Millisecond, "background refresh should have run")
+}
+
+// TestFnCacheNegativeTTL verifies that a failed load is retried sooner than
+// a successful one when NegativeTTL < TTL.
+func TestFnCacheNegativeTTL(t *testing.T) {
+	cache := newFnCacheWithConfig(FnCacheConfig{
+		TTL:         time.Hour,
+		NegativeTTL: time.Millisecond * 10,
+	})
+
+	ctx := context.Background()
+
+	attempts := atomic.NewInt64(0)
+	load := func() (interface{}, error) {
+		n := attempts.Inc()
+		if n == 1 {
+			return nil, trace.Errorf("transient failure")
+		}
+		return "ok", nil
+	}
+
+	_, err := cache.Get(ctx, "key", load)
+	require.Error(t, err)
+
+	// the negative result shouldn't still be cached after NegativeTTL
+	// elapses, well before the hour-long positive TTL would expire.
+	require.Eventually(t, func() bool {
+		v, err := cache.Get(ctx, "key", load)
+		return err == nil && v.(string) == "ok"
+	}, time.Second, time.Millisecond)
+}
+
+// TestFnCacheCancelledDuringStaleRefreshDoesNotPoisonCache verifies that a
+// caller whose context is cancelled while a stale-triggered background
+// refresh is still in flight doesn't leave the cache in a bad state for the
+// next Get.
+func TestFnCacheCancelledDuringStaleRefreshDoesNotPoisonCache(t *testing.T) {
+	cache := newFnCache(time.Millisecond * 10)
+
+	bgCtx := context.Background()
+	_, err := cache.Get(bgCtx, "key", func() (interface{}, error) {
+		return "first", nil
+	})
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 20)
+
+	unblockReload := make(chan struct{})
+	reloadDone := make(chan struct{})
+	reload := func() (interface{}, error) {
+		<-unblockReload
+		close(reloadDone)
+		return "second", nil
+	}
+
+	// this caller observes the stale value immediately and kicks off the
+	// background refresh, but cancels before the refresh finishes.
+	cancelCtx, cancel := context.WithCancel(bgCtx)
+	v, err := cache.GetWithOptions(cancelCtx, "key", reload, GetOptions{StaleOK: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, "first", v.(string))
+	cancel()
+
+	close(unblockReload)
+	<-reloadDone
+
+	// give the background goroutine a moment to finish writing the entry.
+	require.Eventually(t, func() bool {
+		v, err := cache.Get(bgCtx, "key", func() (interface{}, error) {
+			panic("should not be called; refreshed value should still be cached")
+		})
+		return err == nil && v.(string) == "second"
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/gravitational/teleport/lib/tlsca"
+
+	"github.com/gravitational/trace"
+)
+
+// certCache memoizes the parsed *x509.Certificate and tls.Certificate for
+// each of a ClientKey's PEM-encoded certificates, keyed by resource name
+// (e.g. a kubernetes cluster, database or app name, or "" for the primary
+// Teleport TLS cert). Re-parsing and re-pairing with the private key on
+// every call dominates `tsh ls` latency for users with dozens of
+// databases/apps.
+//
+// Entries are keyed by resource name but guarded by a hash of the PEM
+// bytes, so a reissue that replaces a resource's certificate in place
+// (without an explicit InvalidateCert call) still invalidates the stale
+// parse rather than serving it.
+type certCache struct {
+	mu      sync.Mutex
+	entries map[string]*certCacheEntry
+}
+
+type certCacheEntry struct {
+	pemHash  [32]byte
+	x509Cert *x509.Certificate
+	tlsCert  *tls.Certificate
+}
+
+func newCertCache() *certCache {
+	return &certCache{
+		entries: make(map[string]*certCacheEntry),
+	}
+}
+
+// parseX509 returns the cached *x509.Certificate for rawCert under
+// resourceName, parsing and caching it if this is the first lookup or the
+// PEM bytes have changed since the last one.
+func (c *certCache) parseX509(resourceName string, rawCert []byte) (*x509.Certificate, error) {
+	hash := sha256.Sum256(rawCert)
+
+	c.mu.Lock()
+	entry, ok := c.entries[resourceName]
+	if ok && entry.pemHash == hash && entry.x509Cert != nil {
+		cert := entry.x509Cert
+		c.mu.Unlock()
+		return cert, nil
+	}
+	c.mu.Unlock()
+
+	cert, err := tlsca.ParseCertificatePEM(rawCert)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	c.mu.Lock()
+	c.entries[resourceName] = c.mergeLocked(resourceName, hash, cert, nil)
+	c.mu.Unlock()
+
+	return cert, nil
+}
+
+// parseTLSCertificate returns the cached tls.Certificate pairing rawCert
+// with keyPair's signer under resourceName, pairing and caching it if this
+// is the first lookup or the PEM bytes have changed since the last one.
+func (c *certCache) parseTLSCertificate(resourceName string, rawCert []byte, keyPair KeyPair) (tls.Certificate, error) {
+	hash := sha256.Sum256(rawCert)
+
+	c.mu.Lock()
+	entry, ok := c.entries[resourceName]
+	if ok && entry.pemHash == hash && entry.tlsCert != nil {
+		cert := *entry.tlsCert
+		c.mu.Unlock()
+		return cert, nil
+	}
+	c.mu.Unlock()
+
+	tlsCert, err := keyPair.TLSCertificate(rawCert)
+	if err != nil {
+		return tls.Certificate{}, trace.Wrap(err)
+	}
+
+	c.mu.Lock()
+	c.entries[resourceName] = c.mergeLocked(resourceName, hash, nil, &tlsCert)
+	c.mu.Unlock()
+
+	return tlsCert, nil
+}
+
+// mergeLocked folds newly parsed values into any existing entry for
+// resourceName, discarding whichever half (x509/tls) is now stale because
+// the PEM hash changed. c.mu must be held.
+func (c *certCache) mergeLocked(resourceName string, hash [32]byte, x509Cert *x509.Certificate, tlsCert *tls.Certificate) *certCacheEntry {
+	existing, ok := c.entries[resourceName]
+	if !ok || existing.pemHash != hash {
+		return &certCacheEntry{pemHash: hash, x509Cert: x509Cert, tlsCert: tlsCert}
+	}
+	if x509Cert != nil {
+		existing.x509Cert = x509Cert
+	}
+	if tlsCert != nil {
+		existing.tlsCert = tlsCert
+	}
+	return existing
+}
+
+// invalidate drops any cached parse for resourceName, forcing the next
+// lookup to re-parse from PEM.
+func (c *certCache) invalidate(resourceName string) {
+	c.mu.Lock()
+	delete(c.entries, resourceName)
+	c.mu.Unlock()
+}
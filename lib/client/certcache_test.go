@@ -0,0 +1,168 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// generateSelfSignedCert returns a freshly generated, PEM-encoded
+// self-signed certificate, distinct on every call.
+func generateSelfSignedCert(t *testing.T, commonName string) []byte {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+}
+
+func TestCertCacheParseX509(t *testing.T) {
+	cache := newCertCache()
+	rawCert := generateSelfSignedCert(t, "db1.example.com")
+
+	first, err := cache.parseX509("db1", rawCert)
+	require.NoError(t, err)
+	require.Equal(t, "db1.example.com", first.Subject.CommonName)
+
+	second, err := cache.parseX509("db1", rawCert)
+	require.NoError(t, err)
+	require.Same(t, first, second, "unchanged PEM bytes should return the cached *x509.Certificate")
+
+	// A reissued certificate under the same resource name should be
+	// re-parsed rather than served stale.
+	reissued := generateSelfSignedCert(t, "db1-reissued.example.com")
+	third, err := cache.parseX509("db1", reissued)
+	require.NoError(t, err)
+	require.Equal(t, "db1-reissued.example.com", third.Subject.CommonName)
+}
+
+func TestCertCacheInvalidate(t *testing.T) {
+	cache := newCertCache()
+	rawCert := generateSelfSignedCert(t, "app1.example.com")
+
+	first, err := cache.parseX509("app1", rawCert)
+	require.NoError(t, err)
+
+	cache.invalidate("app1")
+
+	second, err := cache.parseX509("app1", rawCert)
+	require.NoError(t, err)
+	require.NotSame(t, first, second, "invalidate should force a re-parse even with identical PEM bytes")
+	require.Equal(t, first.Subject.CommonName, second.Subject.CommonName)
+}
+
+func TestCertCacheConcurrentAccess(t *testing.T) {
+	cache := newCertCache()
+	const resources = 10
+	rawCerts := make([][]byte, resources)
+	for i := range rawCerts {
+		rawCerts[i] = generateSelfSignedCert(t, "concurrent.example.com")
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 50; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				resourceName := string(rune('a' + (worker+i)%resources))
+				_, err := cache.parseX509(resourceName, rawCerts[(worker+i)%resources])
+				require.NoError(t, err)
+				if i%7 == 0 {
+					cache.invalidate(resourceName)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestCertCacheParseTLSCertificate(t *testing.T) {
+	keyPair, err := NewPlainKeyPair()
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube1.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(pemBytesToDER(t, keyPair.privateKeyRaw))
+	require.NoError(t, err)
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	rawCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+
+	cache := newCertCache()
+	first, err := cache.parseTLSCertificate("kube1", rawCert, keyPair)
+	require.NoError(t, err)
+	require.NotNil(t, first.PrivateKey)
+
+	second, err := cache.parseTLSCertificate("kube1", rawCert, keyPair)
+	require.NoError(t, err)
+	require.Equal(t, first.Certificate, second.Certificate)
+}
+
+func pemBytesToDER(t *testing.T, rawPEM []byte) []byte {
+	t.Helper()
+	block, _ := pem.Decode(rawPEM)
+	require.NotNil(t, block)
+	return block.Bytes
+}
+
+func BenchmarkCertCacheParseX509(b *testing.B) {
+	cache := newCertCache()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(b, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "bench.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	require.NoError(b, err)
+	rawCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.parseX509("bench", rawCert); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gravitational/teleport"
@@ -30,7 +31,6 @@ import (
 	"github.com/gravitational/teleport/lib/auth"
 	"github.com/gravitational/teleport/lib/services"
 	"github.com/gravitational/teleport/lib/tlsca"
-	"github.com/gravitational/teleport/lib/utils"
 
 	"github.com/gravitational/trace"
 	"golang.org/x/crypto/ssh"
@@ -87,43 +87,173 @@ type ClientKey struct {
 	WindowsDesktopCerts map[string][]byte `json:"WindowsDesktopCerts,omitempty"`
 	// TrustedCA is a list of trusted certificate authorities
 	TrustedCA []auth.TrustedCerts
+
+	// KeyStoreName is the name of the KeyPair provider that produced
+	// KeyPair (e.g. "software", "yubikey", "pkcs11", "agent"). It's
+	// persisted in the profile so later `tsh` invocations can re-hydrate
+	// the same signer without re-prompting for which key-store to use.
+	KeyStoreName string `json:"KeyStoreName,omitempty"`
+	// KeyStoreHandle is the opaque, provider-specific handle returned by
+	// KeyPair.KeyStoreHandle when this key was created.
+	KeyStoreHandle string `json:"KeyStoreHandle,omitempty"`
+
+	// TLSProfile is the strict-TLS tier this key's connections negotiate
+	// by default. It only affects kube/app/db proxy connections; calls to
+	// the Auth Server always use TLSProfileSecure regardless of this
+	// setting. Defaults to TLSProfileDefault when unset.
+	TLSProfile TLSProfile `json:"TLSProfile,omitempty"`
+
+	// certCacheMu guards lazy initialization of certs below. Neither field
+	// is serialized: the cache is rebuilt from TLSCert/KubeTLSCerts/etc on
+	// first use after a ClientKey is loaded.
+	certCacheMu sync.Mutex
+	certs       *certCache
+}
+
+// certResourceTeleport is the certCache key of the primary Teleport TLS
+// cert; kubeCertResourceName, dbCertResourceName and appCertResourceName
+// below build the keys of per-resource certs.
+const (
+	certResourceTeleport = ""
+)
+
+func kubeCertResourceName(kubeClusterName string) string { return "kube:" + kubeClusterName }
+func dbCertResourceName(dbServiceName string) string     { return "db:" + dbServiceName }
+func appCertResourceName(appName string) string          { return "app:" + appName }
+
+// certCacheOrInit returns this key's certCache, creating it on first use.
+func (k *ClientKey) certCacheOrInit() *certCache {
+	k.certCacheMu.Lock()
+	defer k.certCacheMu.Unlock()
+	if k.certs == nil {
+		k.certs = newCertCache()
+	}
+	return k.certs
+}
+
+// InvalidateCert drops any cached parsed certificate for resourceName (the
+// empty string for the primary Teleport TLS cert, otherwise one of
+// kubeCertResourceName/dbCertResourceName/appCertResourceName), forcing
+// the next lookup to re-parse and re-pair with the private key. Reissue
+// paths that overwrite TLSCert/KubeTLSCerts/DBTLSCerts/AppTLSCerts should
+// call this for the resource they just replaced.
+func (k *ClientKey) InvalidateCert(resourceName string) {
+	k.certCacheOrInit().invalidate(resourceName)
 }
 
-// NewClientKey returns a new unsigned client key, to be signed by
-// a Teleport CA (Auth Server) for client certificates.
-func NewClientKey() (*ClientKey, error) {
-	clientKey := &ClientKey{
-		KubeTLSCerts: make(map[string][]byte),
-		DBTLSCerts:   make(map[string][]byte),
+// tlsProfileOrDefault returns k.TLSProfile, defaulting to TLSProfileDefault
+// for keys loaded from older profiles that predate this field.
+func (k *ClientKey) tlsProfileOrDefault() TLSProfile {
+	if k.TLSProfile == "" {
+		return TLSProfileDefault
 	}
+	return k.TLSProfile
+}
+
+// NewClientKey returns a new unsigned client key, to be signed by a
+// Teleport CA (Auth Server) for client certificates. keyStoreSpec selects
+// the KeyPair provider, as parsed by ParseKeyStoreSpec from a `tsh login
+// --key-store=...` flag; an empty spec defaults to a software key.
+func NewClientKey(keyStoreSpec KeyPairSpec) (*ClientKey, error) {
+	keyPair, err := NewKeyPair(keyStoreSpec)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &ClientKey{
+		KeyPair:        keyPair,
+		KubeTLSCerts:   make(map[string][]byte),
+		DBTLSCerts:     make(map[string][]byte),
+		KeyStoreName:   keyPair.KeyStoreName(),
+		KeyStoreHandle: keyPair.KeyStoreHandle(),
+	}, nil
+}
+
+// RehydrateClientKey re-opens the KeyPair this key was created with, using
+// its persisted KeyStoreName/KeyStoreHandle. Callers load everything else
+// (certs, trusted CAs) from the on-disk profile as usual; this only
+// recovers the signer, which for hardware-backed keys can't be serialized.
+//
+// This is only meaningful for hardware-backed keys: a software key's
+// private key material doesn't survive in KeyStoreHandle (PlainKeyPair
+// leaves it empty), so rehydrating one would silently mint an unrelated
+// throwaway key that won't match the already-issued certificate. Software
+// keys must be loaded directly from wherever their raw key bytes are
+// persisted instead of going through this path.
+func (k *ClientKey) RehydrateClientKey() error {
+	if k.KeyStoreName == "" || k.KeyStoreName == "software" {
+		return trace.BadParameter("key-store %q has no hardware-backed signer to re-hydrate; load its private key directly instead", k.KeyStoreName)
+	}
+	keyPair, err := NewKeyPair(KeyPairSpec{Name: k.KeyStoreName, Handle: k.KeyStoreHandle})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	k.KeyPair = keyPair
+	return nil
+}
+
+// TeleportClientTLSConfig returns client TLS configuration used to
+// authenticate against API servers. Auth-server-bound calls always
+// negotiate at TLSProfileSecure, regardless of the key's configured
+// TLSProfile, since the Auth Server is never a legacy target.
+func (k *ClientKey) TeleportClientTLSConfig(cipherSuites []uint16, clusters []string) (*tls.Config, error) {
+	return k.clientTLSConfig(TLSProfileSecure, cipherSuites, certResourceTeleport, k.TLSCert, clusters)
+}
 
-	var err error
-	clientKey.KeyPair, err = NewYkKeyPair()
-	if err == nil {
-		return clientKey, nil
-	} else {
+// KubeClientTLSConfig returns client TLS configuration for a kubernetes
+// proxy connection, negotiated at this key's configured TLSProfile
+// (TLSProfileDefault unless the key opted into something stricter).
+func (k *ClientKey) KubeClientTLSConfig(cipherSuites []uint16, kubeClusterName string) (*tls.Config, error) {
+	rootCluster, err := k.RootClusterName()
+	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	// } else if err != nil && !trace.IsNotFound(err) {
-	// 	return nil, trace.Wrap(err)
-	// }
+	tlsCert, ok := k.KubeTLSCerts[kubeClusterName]
+	if !ok {
+		return nil, trace.NotFound("TLS certificate for kubernetes cluster %q not found", kubeClusterName)
+	}
 
-	clientKey.KeyPair, err = NewPlainKeyPair()
+	tlsConfig, err := k.clientTLSConfig(k.tlsProfileOrDefault(), cipherSuites, kubeCertResourceName(kubeClusterName), tlsCert, []string{rootCluster})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
+	tlsConfig.ServerName = fmt.Sprintf("%s%s", constants.KubeSNIPrefix, constants.APIDomain)
+	return tlsConfig, nil
+}
 
-	return clientKey, nil
+// DBClientTLSConfig returns client TLS configuration for a database proxy
+// connection for the named database service. profile should normally be
+// k.tlsProfileOrDefault(); callers pass it explicitly so a db_server
+// annotation opting a specific database into TLSProfileCompat doesn't have
+// to mutate the shared ClientKey.
+func (k *ClientKey) DBClientTLSConfig(cipherSuites []uint16, dbServiceName string, profile TLSProfile) (*tls.Config, error) {
+	rootCluster, err := k.RootClusterName()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	tlsCert, ok := k.DBTLSCerts[dbServiceName]
+	if !ok {
+		return nil, trace.NotFound("TLS certificate for database %q not found", dbServiceName)
+	}
+	return k.clientTLSConfig(profile, cipherSuites, dbCertResourceName(dbServiceName), tlsCert, []string{rootCluster})
 }
 
-// TeleportClientTLSConfig returns client TLS configuration used
-// to authenticate against API servers.
-func (k *ClientKey) TeleportClientTLSConfig(cipherSuites []uint16, clusters []string) (*tls.Config, error) {
-	return k.clientTLSConfig(cipherSuites, k.TLSCert, clusters)
+// AppClientTLSConfig returns client TLS configuration for an application
+// proxy connection for the named application.
+func (k *ClientKey) AppClientTLSConfig(cipherSuites []uint16, appName string) (*tls.Config, error) {
+	rootCluster, err := k.RootClusterName()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	tlsCert, ok := k.AppTLSCerts[appName]
+	if !ok {
+		return nil, trace.NotFound("TLS certificate for application %q not found", appName)
+	}
+	return k.clientTLSConfig(k.tlsProfileOrDefault(), cipherSuites, appCertResourceName(appName), tlsCert, []string{rootCluster})
 }
 
-func (k *ClientKey) clientTLSConfig(cipherSuites []uint16, tlsCertRaw []byte, clusters []string) (*tls.Config, error) {
-	tlsCert, err := k.KeyPair.TLSCertificate(tlsCertRaw)
+func (k *ClientKey) clientTLSConfig(profile TLSProfile, cipherSuites []uint16, resourceName string, tlsCertRaw []byte, clusters []string) (*tls.Config, error) {
+	tlsCert, err := k.certCacheOrInit().parseTLSCertificate(resourceName, tlsCertRaw, k.KeyPair)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -143,7 +273,10 @@ func (k *ClientKey) clientTLSConfig(cipherSuites []uint16, tlsCertRaw []byte, cl
 		}
 	}
 
-	tlsConfig := utils.TLSConfig(cipherSuites)
+	tlsConfig, err := profile.baseTLSConfig(cipherSuites)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
 	tlsConfig.RootCAs = pool
 	tlsConfig.Certificates = append(tlsConfig.Certificates, tlsCert)
 
@@ -204,6 +337,13 @@ func KeyFromIdentityFile(path string) (*ClientKey, error) {
 		return nil, trace.Wrap(err, "failed to parse identity file")
 	}
 
+	// An identity file may reference a PKCS#11-resident key by URI instead
+	// of embedding PEM key material, e.g. when it was exported for a host
+	// that signs via an HSM.
+	if IsPKCS11URI(ident.PrivateKey) {
+		return keyFromPKCS11IdentityFile(ident)
+	}
+
 	// validate both by parsing them:
 	privKey, err := ssh.ParseRawPrivateKey(ident.PrivateKey)
 	if err != nil {
@@ -263,10 +403,54 @@ func KeyFromIdentityFile(path string) (*ClientKey, error) {
 			privateKeyRaw: ident.PrivateKey,
 			publicKeyRaw:  ssh.MarshalAuthorizedKey(signer.PublicKey()),
 		},
-		Cert:       ident.Certs.SSH,
-		TLSCert:    ident.Certs.TLS,
-		TrustedCA:  trustedCA,
-		DBTLSCerts: dbTLSCerts,
+		KeyStoreName: "software",
+		Cert:         ident.Certs.SSH,
+		TLSCert:      ident.Certs.TLS,
+		TrustedCA:    trustedCA,
+		DBTLSCerts:   dbTLSCerts,
+	}, nil
+}
+
+// keyFromPKCS11IdentityFile builds a ClientKey from an identity file whose
+// private key field holds a PKCS#11 URI rather than PEM key material. The
+// signer itself is opened from the HSM via the pkcs11 KeyPair provider
+// while the key is loaded; only certificates and trusted CAs are read from
+// the file.
+func keyFromPKCS11IdentityFile(ident *identityfile.IdentityFile) (*ClientKey, error) {
+	uri := string(ident.PrivateKey)
+
+	keyPair, err := NewKeyPair(KeyPairSpec{Name: "pkcs11", Handle: uri})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	dbTLSCerts := make(map[string][]byte)
+	if len(ident.Certs.TLS) > 0 {
+		parsedIdent, err := extractIdentityFromCert(ident.Certs.TLS)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if parsedIdent.RouteToDatabase.ServiceName != "" {
+			dbTLSCerts[parsedIdent.RouteToDatabase.ServiceName] = ident.Certs.TLS
+		}
+	}
+
+	var trustedCA []auth.TrustedCerts
+	if len(ident.CACerts.TLS) > 0 || len(ident.CACerts.SSH) > 0 {
+		trustedCA = []auth.TrustedCerts{{
+			TLSCertificates:  ident.CACerts.TLS,
+			HostCertificates: ident.CACerts.SSH,
+		}}
+	}
+
+	return &ClientKey{
+		KeyPair:        keyPair,
+		KeyStoreName:   keyPair.KeyStoreName(),
+		KeyStoreHandle: keyPair.KeyStoreHandle(),
+		Cert:           ident.Certs.SSH,
+		TLSCert:        ident.Certs.TLS,
+		TrustedCA:      trustedCA,
+		DBTLSCerts:     dbTLSCerts,
 	}, nil
 }
 
@@ -302,24 +486,6 @@ func (k *ClientKey) TLSCAs() (result [][]byte) {
 	return result
 }
 
-func (k *ClientKey) KubeClientTLSConfig(cipherSuites []uint16, kubeClusterName string) (*tls.Config, error) {
-	rootCluster, err := k.RootClusterName()
-	if err != nil {
-		return nil, trace.Wrap(err)
-	}
-	tlsCert, ok := k.KubeTLSCerts[kubeClusterName]
-	if !ok {
-		return nil, trace.NotFound("TLS certificate for kubernetes cluster %q not found", kubeClusterName)
-	}
-
-	tlsConfig, err := k.clientTLSConfig(cipherSuites, tlsCert, []string{rootCluster})
-	if err != nil {
-		return nil, trace.Wrap(err)
-	}
-	tlsConfig.ServerName = fmt.Sprintf("%s%s", constants.KubeSNIPrefix, constants.APIDomain)
-	return tlsConfig, nil
-}
-
 // SSHCAs returns all SSH CA certificates from this key
 func (k *ClientKey) SSHCAs() (result [][]byte) {
 	for _, ca := range k.TrustedCA {
@@ -398,7 +564,7 @@ func (k *ClientKey) AsAgentKeys() ([]agent.AddedKey, error) {
 // TeleportTLSCertificate returns the parsed x509 certificate for
 // authentication against Teleport APIs.
 func (k *ClientKey) TeleportTLSCertificate() (*x509.Certificate, error) {
-	return tlsca.ParseCertificatePEM(k.TLSCert)
+	return k.certCacheOrInit().parseX509(certResourceTeleport, k.TLSCert)
 }
 
 // KubeTLSCertificate returns the parsed x509 certificate for
@@ -408,13 +574,14 @@ func (k *ClientKey) KubeTLSCertificate(kubeClusterName string) (*x509.Certificat
 	if !ok {
 		return nil, trace.NotFound("TLS certificate for kubernetes cluster %q not found", kubeClusterName)
 	}
-	return tlsca.ParseCertificatePEM(tlsCert)
+	return k.certCacheOrInit().parseX509(kubeCertResourceName(kubeClusterName), tlsCert)
 }
 
 // DBTLSCertificates returns all parsed x509 database access certificates.
 func (k *ClientKey) DBTLSCertificates() (certs []x509.Certificate, err error) {
-	for _, bytes := range k.DBTLSCerts {
-		cert, err := tlsca.ParseCertificatePEM(bytes)
+	cache := k.certCacheOrInit()
+	for name, bytes := range k.DBTLSCerts {
+		cert, err := cache.parseX509(dbCertResourceName(name), bytes)
 		if err != nil {
 			return nil, trace.Wrap(err)
 		}
@@ -425,8 +592,9 @@ func (k *ClientKey) DBTLSCertificates() (certs []x509.Certificate, err error) {
 
 // AppTLSCertificates returns all parsed x509 app access certificates.
 func (k *ClientKey) AppTLSCertificates() (certs []x509.Certificate, err error) {
-	for _, bytes := range k.AppTLSCerts {
-		cert, err := tlsca.ParseCertificatePEM(bytes)
+	cache := k.certCacheOrInit()
+	for name, bytes := range k.AppTLSCerts {
+		cert, err := cache.parseX509(appCertResourceName(name), bytes)
 		if err != nil {
 			return nil, trace.Wrap(err)
 		}
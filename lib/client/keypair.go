@@ -0,0 +1,174 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// parseSingleCertPEM decodes the first PEM block of rawCert and returns its
+// raw DER bytes, for hardware-backed KeyPairs that build a tls.Certificate
+// without going through tls.X509KeyPair (which requires raw key bytes).
+func parseSingleCertPEM(rawCert []byte) ([]byte, error) {
+	block, _ := pem.Decode(rawCert)
+	if block == nil {
+		return nil, trace.BadParameter("invalid PEM certificate")
+	}
+	return block.Bytes, nil
+}
+
+// KeyPair abstracts over a private key that may or may not be backed by
+// hardware. Implementations must never expose raw private key material
+// outside of the package that constructs them; all signing happens behind
+// TLSCertificate, SSHSigner and AsAgentKeys.
+type KeyPair interface {
+	// TLSCertificate pairs rawCert (a PEM-encoded x509 certificate) with
+	// this KeyPair's private key/signer, returning a tls.Certificate
+	// suitable for use in a tls.Config.
+	TLSCertificate(rawCert []byte) (tls.Certificate, error)
+	// SSHSigner returns an ssh.Signer backed by this KeyPair's private key.
+	SSHSigner() (ssh.Signer, error)
+	// AsAgentKeys returns the []agent.AddedKey representation of this
+	// KeyPair paired with the given SSH certificate, suitable for loading
+	// into an SSH agent.
+	AsAgentKeys(cert *ssh.Certificate) ([]agent.AddedKey, error)
+	// PublicKeyRaw returns the authorized_keys-format public key.
+	PublicKeyRaw() []byte
+	// KeyStoreName identifies the provider that produced this KeyPair
+	// (e.g. "software", "yubikey", "pkcs11", "agent").
+	KeyStoreName() string
+	// KeyStoreHandle is an opaque, provider-specific string that's enough
+	// to re-hydrate an equivalent KeyPair via the registry without
+	// re-prompting the user (a PIV slot, a PKCS#11 URI, an agent key
+	// comment, etc). It contains no secret material.
+	KeyStoreHandle() string
+}
+
+// KeyPairSpec describes the hardware-backed (or software) signer a caller
+// wants, as parsed from a `--key-store=<name>:<opt>=<val>,...` flag or from
+// a profile's persisted provider name + handle.
+type KeyPairSpec struct {
+	// Name is the registered provider name, e.g. "yubikey", "pkcs11", "agent".
+	Name string
+	// Handle is the provider-specific selector: a PIV slot for yubikey, a
+	// PKCS#11 URI for pkcs11, a key comment for agent. Empty for software
+	// keys and for providers happy to pick a sensible default.
+	Handle string
+	// Options carries provider-specific key=value settings parsed out of
+	// the spec (e.g. touch=cached, pin=ask).
+	Options map[string]string
+}
+
+// KeyPairFactory constructs a KeyPair from a parsed spec. Factories are
+// expected to prompt for PIN/touch as needed; they must not block on
+// anything the caller didn't ask for (e.g. a factory must not probe for
+// hardware that wasn't requested).
+type KeyPairFactory func(spec KeyPairSpec) (KeyPair, error)
+
+var (
+	keyPairRegistryMu sync.Mutex
+	keyPairRegistry   = map[string]KeyPairFactory{}
+)
+
+// RegisterKeyPairProvider registers a KeyPair provider under name, so it can
+// be selected via `--key-store=<name>:...` or a persisted profile. Providers
+// are expected to call this from an init() function; registering the same
+// name twice is a programming error and panics, matching the standard
+// library's database/sql driver registration pattern.
+func RegisterKeyPairProvider(name string, factory KeyPairFactory) {
+	keyPairRegistryMu.Lock()
+	defer keyPairRegistryMu.Unlock()
+	if _, ok := keyPairRegistry[name]; ok {
+		panic(fmt.Sprintf("client: KeyPair provider %q already registered", name))
+	}
+	keyPairRegistry[name] = factory
+}
+
+func init() {
+	RegisterKeyPairProvider("software", func(spec KeyPairSpec) (KeyPair, error) {
+		return NewPlainKeyPair()
+	})
+	RegisterKeyPairProvider("yubikey", func(spec KeyPairSpec) (KeyPair, error) {
+		return NewYkKeyPair(spec)
+	})
+	RegisterKeyPairProvider("pkcs11", func(spec KeyPairSpec) (KeyPair, error) {
+		return NewPKCS11KeyPair(spec)
+	})
+	RegisterKeyPairProvider("agent", func(spec KeyPairSpec) (KeyPair, error) {
+		return NewAgentKeyPair(spec)
+	})
+}
+
+// ParseKeyStoreSpec parses a `--key-store` flag value of the form
+// "<name>" or "<name>:opt1=val1,opt2=val2" (e.g. "yubikey:slot=9a,touch=cached"
+// or "pkcs11:uri=pkcs11:token=MyHSM;object=teleport").
+func ParseKeyStoreSpec(raw string) (KeyPairSpec, error) {
+	if raw == "" {
+		return KeyPairSpec{Name: "software"}, nil
+	}
+
+	name, rest, _ := strings.Cut(raw, ":")
+	spec := KeyPairSpec{
+		Name:    name,
+		Options: make(map[string]string),
+	}
+	if rest == "" {
+		return spec, nil
+	}
+
+	for _, pair := range strings.Split(rest, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return KeyPairSpec{}, trace.BadParameter("invalid key-store option %q, expected key=value", pair)
+		}
+		if k == "slot" || k == "uri" || k == "comment" {
+			spec.Handle = v
+		}
+		spec.Options[k] = v
+	}
+	return spec, nil
+}
+
+// NewKeyPair constructs a KeyPair from spec using the registered provider
+// matching spec.Name. It is the single entry point tsh and re-hydration
+// from a saved profile should use to obtain a signer.
+func NewKeyPair(spec KeyPairSpec) (KeyPair, error) {
+	if spec.Name == "" {
+		spec.Name = "software"
+	}
+
+	keyPairRegistryMu.Lock()
+	factory, ok := keyPairRegistry[spec.Name]
+	keyPairRegistryMu.Unlock()
+	if !ok {
+		return nil, trace.BadParameter("unknown key-store provider %q", spec.Name)
+	}
+
+	keyPair, err := factory(spec)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return keyPair, nil
+}
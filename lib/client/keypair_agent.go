@@ -0,0 +1,113 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+// AgentKeyPair is a KeyPair backed by a key that already lives in the
+// user's running ssh-agent, selected by key comment. Teleport never sees
+// the private key; every signature is requested over the agent socket.
+type AgentKeyPair struct {
+	comment      string
+	publicKeyRaw []byte
+	agentClient  sshagent.ExtendedAgent
+	agentKey     ssh.PublicKey
+}
+
+// NewAgentKeyPair locates a key named by spec.Handle (its comment) in the
+// ssh-agent reachable via SSH_AUTH_SOCK and returns a KeyPair backed by it.
+func NewAgentKeyPair(spec KeyPairSpec) (*AgentKeyPair, error) {
+	comment := spec.Handle
+	if comment == "" {
+		return nil, trace.BadParameter("agent key-store requires a key comment, e.g. --key-store=agent:comment=yubikey-9a")
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, trace.NotFound("SSH_AUTH_SOCK is not set; no ssh-agent to connect to")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to connect to ssh-agent")
+	}
+
+	agentClient := sshagent.NewClient(conn)
+	keys, err := agentClient.List()
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to list ssh-agent keys")
+	}
+
+	for _, k := range keys {
+		if k.Comment == comment {
+			return &AgentKeyPair{
+				comment:      comment,
+				publicKeyRaw: ssh.MarshalAuthorizedKey(k),
+				agentClient:  agentClient,
+				agentKey:     k,
+			}, nil
+		}
+	}
+	return nil, trace.NotFound("no key with comment %q found in ssh-agent", comment)
+}
+
+// TLSCertificate is not supported for agent-resident keys: ssh-agent only
+// speaks the SSH signing protocol, not TLS.
+func (a *AgentKeyPair) TLSCertificate(rawCert []byte) (tls.Certificate, error) {
+	return tls.Certificate{}, trace.BadParameter("agent-backed keys cannot be used for TLS; pick a different --key-store for database/kube/app access")
+}
+
+// SSHSigner returns an ssh.Signer that requests signatures from the agent.
+func (a *AgentKeyPair) SSHSigner() (ssh.Signer, error) {
+	signers, err := a.agentClient.Signers()
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to list signers from ssh-agent")
+	}
+	for _, signer := range signers {
+		if ssh.FingerprintSHA256(signer.PublicKey()) == ssh.FingerprintSHA256(a.agentKey) {
+			return signer, nil
+		}
+	}
+	return nil, trace.NotFound("key with comment %q is no longer present in ssh-agent", a.comment)
+}
+
+// AsAgentKeys is a no-op: the key already lives in the agent.
+func (a *AgentKeyPair) AsAgentKeys(cert *ssh.Certificate) ([]sshagent.AddedKey, error) {
+	return nil, nil
+}
+
+// PublicKeyRaw returns the authorized_keys-format public key.
+func (a *AgentKeyPair) PublicKeyRaw() []byte {
+	return a.publicKeyRaw
+}
+
+// KeyStoreName identifies this provider as "agent".
+func (a *AgentKeyPair) KeyStoreName() string {
+	return "agent"
+}
+
+// KeyStoreHandle is the key's comment, enough to re-locate it in the agent.
+func (a *AgentKeyPair) KeyStoreHandle() string {
+	return a.comment
+}
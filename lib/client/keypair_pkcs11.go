@@ -0,0 +1,125 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto"
+	"crypto/tls"
+	"strings"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// pkcs11URIPrefix identifies a PKCS#11 URI per RFC 7512, as opposed to a
+// PEM-encoded private key.
+const pkcs11URIPrefix = "pkcs11:"
+
+// IsPKCS11URI reports whether raw looks like an RFC 7512 PKCS#11 URI rather
+// than PEM-encoded key material.
+func IsPKCS11URI(raw []byte) bool {
+	return strings.HasPrefix(strings.TrimSpace(string(raw)), pkcs11URIPrefix)
+}
+
+// PKCS11KeyPair is a KeyPair backed by a slot/object on a PKCS#11 HSM,
+// selected by URI (RFC 7512), e.g.
+// "pkcs11:token=MyHSM;object=teleport-key;pin-source=file:/run/hsm.pin".
+type PKCS11KeyPair struct {
+	uri          string
+	publicKeyRaw []byte
+	signer       crypto.Signer
+}
+
+// NewPKCS11KeyPair opens the PKCS#11 object named by spec.Handle (a PKCS#11
+// URI) and returns a KeyPair backed by it.
+func NewPKCS11KeyPair(spec KeyPairSpec) (*PKCS11KeyPair, error) {
+	uri := spec.Handle
+	if uri == "" {
+		return nil, trace.BadParameter("pkcs11 key-store requires a uri, e.g. --key-store=pkcs11:uri=pkcs11:token=MyHSM;object=teleport")
+	}
+
+	cfg, err := crypto11.ConfigureFromPKCS11URI(uri)
+	if err != nil {
+		return nil, trace.Wrap(err, "invalid PKCS#11 URI %q", uri)
+	}
+
+	ctx, err := crypto11.Configure(cfg)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to initialize PKCS#11 module")
+	}
+
+	signer, err := ctx.FindKeyPairFromURI(uri)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to locate PKCS#11 object for %q", uri)
+	}
+
+	sshPub, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &PKCS11KeyPair{
+		uri:          uri,
+		publicKeyRaw: ssh.MarshalAuthorizedKey(sshPub),
+		signer:       signer,
+	}, nil
+}
+
+// TLSCertificate pairs rawCert with the HSM-resident private key.
+func (p *PKCS11KeyPair) TLSCertificate(rawCert []byte) (tls.Certificate, error) {
+	der, err := parseSingleCertPEM(rawCert)
+	if err != nil {
+		return tls.Certificate{}, trace.Wrap(err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  p.signer,
+	}, nil
+}
+
+// SSHSigner returns an ssh.Signer that delegates signing to the HSM.
+func (p *PKCS11KeyPair) SSHSigner() (ssh.Signer, error) {
+	signer, err := ssh.NewSignerFromSigner(p.signer)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return signer, nil
+}
+
+// AsAgentKeys is not supported: the HSM's signer can't be handed to an
+// ssh-agent process, which needs the raw private key.
+func (p *PKCS11KeyPair) AsAgentKeys(cert *ssh.Certificate) ([]agent.AddedKey, error) {
+	return nil, trace.BadParameter("PKCS#11-backed keys cannot be loaded into an SSH agent; use --key-store=pkcs11 directly")
+}
+
+// PublicKeyRaw returns the authorized_keys-format public key.
+func (p *PKCS11KeyPair) PublicKeyRaw() []byte {
+	return p.publicKeyRaw
+}
+
+// KeyStoreName identifies this provider as "pkcs11".
+func (p *PKCS11KeyPair) KeyStoreName() string {
+	return "pkcs11"
+}
+
+// KeyStoreHandle is the PKCS#11 URI itself: it's already opaque, contains
+// no secret material, and is sufficient to re-open the same object.
+func (p *PKCS11KeyPair) KeyStoreHandle() string {
+	return p.uri
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyStoreSpec(t *testing.T) {
+	tests := []struct {
+		desc       string
+		raw        string
+		wantName   string
+		wantHandle string
+		wantErr    bool
+	}{
+		{desc: "empty defaults to software", raw: "", wantName: "software", wantHandle: ""},
+		{desc: "bare name, no options", raw: "yubikey", wantName: "yubikey", wantHandle: ""},
+		{desc: "yubikey slot populates Handle", raw: "yubikey:slot=9a,touch=cached", wantName: "yubikey", wantHandle: "9a"},
+		{desc: "pkcs11 uri populates Handle", raw: "pkcs11:uri=pkcs11:token=MyHSM;object=teleport", wantName: "pkcs11", wantHandle: "pkcs11:token=MyHSM;object=teleport"},
+		{desc: "agent comment populates Handle", raw: "agent:comment=yubikey-9a", wantName: "agent", wantHandle: "yubikey-9a"},
+		{desc: "malformed option errors", raw: "yubikey:slot", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			spec, err := ParseKeyStoreSpec(tt.raw)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantName, spec.Name)
+			require.Equal(t, tt.wantHandle, spec.Handle)
+		})
+	}
+}
+
+func TestRegisterKeyPairProviderPanicsOnDuplicate(t *testing.T) {
+	const name = "test-duplicate-provider"
+	factory := func(spec KeyPairSpec) (KeyPair, error) { return nil, nil }
+
+	RegisterKeyPairProvider(name, factory)
+	require.Panics(t, func() { RegisterKeyPairProvider(name, factory) })
+}
+
+func TestNewKeyPairUnknownProvider(t *testing.T) {
+	_, err := NewKeyPair(KeyPairSpec{Name: "not-a-real-provider"})
+	require.Error(t, err)
+}
+
+func TestNewKeyPairDefaultsToSoftware(t *testing.T) {
+	keyPair, err := NewKeyPair(KeyPairSpec{})
+	require.NoError(t, err)
+	require.Equal(t, "software", keyPair.KeyStoreName())
+}
+
+func TestNewAgentKeyPairRequiresComment(t *testing.T) {
+	_, err := NewAgentKeyPair(KeyPairSpec{})
+	require.Error(t, err)
+}
+
+func TestNewPKCS11KeyPairRequiresURI(t *testing.T) {
+	_, err := NewPKCS11KeyPair(KeyPairSpec{})
+	require.Error(t, err)
+}
+
+func TestNewYkKeyPairRejectsUnknownSlot(t *testing.T) {
+	_, err := NewYkKeyPair(KeyPairSpec{Handle: "not-a-slot"})
+	require.Error(t, err)
+}
+
+func TestPlainKeyPairMetadataAndSSHSigner(t *testing.T) {
+	keyPair, err := NewPlainKeyPair()
+	require.NoError(t, err)
+	require.Equal(t, "software", keyPair.KeyStoreName())
+	require.Empty(t, keyPair.KeyStoreHandle())
+
+	signer, err := keyPair.SSHSigner()
+	require.NoError(t, err)
+	require.NotNil(t, signer)
+}
+
+// TestRehydrateClientKeyRejectsSoftware guards against regressing into the
+// bug where RehydrateClientKey silently swapped in a brand-new random key
+// for a software-backed ClientKey instead of failing: PlainKeyPair's
+// KeyStoreHandle is always empty, so there's nothing to re-hydrate from.
+func TestRehydrateClientKeyRejectsSoftware(t *testing.T) {
+	key := &ClientKey{KeyStoreName: "software"}
+	require.Error(t, key.RehydrateClientKey())
+
+	key = &ClientKey{}
+	require.Error(t, key.RehydrateClientKey())
+}
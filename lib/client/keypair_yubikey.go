@@ -0,0 +1,179 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto"
+	"crypto/tls"
+
+	"github.com/go-piv/piv-go/piv"
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// defaultPIVSlot is used when the key-store spec doesn't name one.
+const defaultPIVSlot = "9a"
+
+// YkKeyPair is a KeyPair backed by a PIV slot on a YubiKey. Every signing
+// operation is delegated to the device; the private key never leaves it.
+// The slot's touch policy (if any) is enforced by the device itself and
+// isn't something this client configures or prompts for.
+type YkKeyPair struct {
+	slotName     string
+	slot         piv.Slot
+	serial       uint32
+	publicKeyRaw []byte
+	signer       crypto.Signer
+}
+
+// NewYkKeyPair opens a PIV slot on the first attached YubiKey matching spec
+// and returns a KeyPair backed by it. spec.Handle selects the slot (e.g.
+// "9a", "9c", "9d", "9e"). spec.Options["pin"] supplies the PIV PIN
+// directly, e.g. from a secrets manager. Without it (or with pin=ask), a
+// slot whose PIN policy requires a PIN can't be used: pinPrompt returns an
+// error instead of prompting.
+func NewYkKeyPair(spec KeyPairSpec) (*YkKeyPair, error) {
+	slotName := spec.Handle
+	if slotName == "" {
+		slotName = defaultPIVSlot
+	}
+	slot, ok := pivSlotByName(slotName)
+	if !ok {
+		return nil, trace.BadParameter("invalid YubiKey PIV slot %q", slotName)
+	}
+
+	cards, err := piv.Cards()
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to enumerate YubiKeys")
+	}
+	if len(cards) == 0 {
+		return nil, trace.NotFound("no YubiKey detected")
+	}
+
+	yk, err := piv.Open(cards[0])
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to open YubiKey")
+	}
+
+	pub, err := yk.Certificate(slot)
+	if err != nil {
+		return nil, trace.Wrap(err, "no certificate in PIV slot %q; run `tsh login --key-store=yubikey:slot=%s` to provision it", slotName, slotName)
+	}
+
+	auth := piv.KeyAuth{}
+	if pin := spec.Options["pin"]; pin != "" && pin != "ask" {
+		auth.PIN = pin
+	} else {
+		auth.PINPrompt = pinPrompt
+	}
+
+	priv, err := yk.PrivateKey(slot, pub.PublicKey, auth)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to access private key in PIV slot %q", slotName)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, trace.BadParameter("PIV slot %q does not hold a signing key", slotName)
+	}
+
+	sshPub, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	serial, err := yk.Serial()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &YkKeyPair{
+		slotName:     slotName,
+		slot:         slot,
+		serial:       serial,
+		publicKeyRaw: ssh.MarshalAuthorizedKey(sshPub),
+		signer:       signer,
+	}, nil
+}
+
+func pivSlotByName(name string) (piv.Slot, bool) {
+	switch name {
+	case "9a":
+		return piv.SlotAuthentication, true
+	case "9c":
+		return piv.SlotSignature, true
+	case "9d":
+		return piv.SlotKeyManagement, true
+	case "9e":
+		return piv.SlotCardAuthentication, true
+	default:
+		return piv.Slot{}, false
+	}
+}
+
+// pinPrompt is used when no PIN was supplied via spec.Options["pin"]. It
+// does not prompt; it always returns a NotImplemented error.
+func pinPrompt() (string, error) {
+	return "", trace.NotImplemented("no PIV PIN supplied and PIN prompting is not supported")
+}
+
+// TLSCertificate pairs rawCert with the YubiKey-resident private key.
+func (y *YkKeyPair) TLSCertificate(rawCert []byte) (tls.Certificate, error) {
+	block, err := parseSingleCertPEM(rawCert)
+	if err != nil {
+		return tls.Certificate{}, trace.Wrap(err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{block},
+		PrivateKey:  y.signer,
+	}, nil
+}
+
+// SSHSigner returns an ssh.Signer that delegates signing to the YubiKey.
+func (y *YkKeyPair) SSHSigner() (ssh.Signer, error) {
+	signer, err := ssh.NewSignerFromSigner(y.signer)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return signer, nil
+}
+
+// AsAgentKeys is not supported for hardware-backed keys: ssh-agent has no
+// concept of a remote signer, so the certificate is presented directly by
+// the client instead of being loaded into an agent.
+func (y *YkKeyPair) AsAgentKeys(cert *ssh.Certificate) ([]agent.AddedKey, error) {
+	return nil, trace.BadParameter("YubiKey-backed keys cannot be loaded into an SSH agent; use --key-store=yubikey directly")
+}
+
+// PublicKeyRaw returns the authorized_keys-format public key.
+func (y *YkKeyPair) PublicKeyRaw() []byte {
+	return y.publicKeyRaw
+}
+
+// KeyStoreName identifies this provider as "yubikey".
+func (y *YkKeyPair) KeyStoreName() string {
+	return "yubikey"
+}
+
+// KeyStoreHandle is the PIV slot name (e.g. "9a"), the same form accepted
+// as spec.Handle by NewYkKeyPair, so a future `tsh` invocation can re-open
+// the same slot without re-prompting for which one to use. The YubiKey's
+// serial number is informational only (NewYkKeyPair always uses the first
+// attached device) and isn't part of the handle.
+func (y *YkKeyPair) KeyStoreHandle() string {
+	return y.slotName
+}
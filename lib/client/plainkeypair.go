@@ -0,0 +1,116 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// generateKeyPair creates a new RSA private key and returns its PEM-encoded
+// private key and authorized_keys-format public key.
+func generateKeyPair() (privateKeyRaw, publicKeyRaw []byte, err error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	privPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	})
+
+	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	return privPEM, ssh.MarshalAuthorizedKey(sshPub), nil
+}
+
+// PlainKeyPair is a KeyPair backed by an in-memory, unencrypted private key.
+// It's the default when no hardware-backed key-store is requested.
+type PlainKeyPair struct {
+	privateKeyRaw []byte
+	publicKeyRaw  []byte
+}
+
+// NewPlainKeyPair generates a new software KeyPair.
+func NewPlainKeyPair() (*PlainKeyPair, error) {
+	priv, pub, err := generateKeyPair()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &PlainKeyPair{
+		privateKeyRaw: priv,
+		publicKeyRaw:  pub,
+	}, nil
+}
+
+// TLSCertificate pairs rawCert with this key's raw private key bytes.
+func (p *PlainKeyPair) TLSCertificate(rawCert []byte) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(rawCert, p.privateKeyRaw)
+	if err != nil {
+		return tls.Certificate{}, trace.Wrap(err)
+	}
+	return cert, nil
+}
+
+// SSHSigner returns an ssh.Signer for this key.
+func (p *PlainKeyPair) SSHSigner() (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(p.privateKeyRaw)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return signer, nil
+}
+
+// AsAgentKeys returns a single agent.AddedKey pairing this private key with
+// cert.
+func (p *PlainKeyPair) AsAgentKeys(cert *ssh.Certificate) ([]agent.AddedKey, error) {
+	priv, err := ssh.ParseRawPrivateKey(p.privateKeyRaw)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return []agent.AddedKey{{
+		PrivateKey:  priv,
+		Certificate: cert,
+	}}, nil
+}
+
+// PublicKeyRaw returns the authorized_keys-format public key.
+func (p *PlainKeyPair) PublicKeyRaw() []byte {
+	return p.publicKeyRaw
+}
+
+// KeyStoreName identifies this provider as "software".
+func (p *PlainKeyPair) KeyStoreName() string {
+	return "software"
+}
+
+// KeyStoreHandle is empty for software keys: there's nothing to re-hydrate,
+// the raw key itself is the profile-persisted state.
+func (p *PlainKeyPair) KeyStoreHandle() string {
+	return ""
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// TLSProfile names a tiered TLS strictness level for outbound client
+// connections. Higher tiers are stricter (fewer ciphers, higher minimum
+// version); lower tiers exist only for legacy targets that can't be
+// upgraded.
+type TLSProfile string
+
+const (
+	// TLSProfileSecure requires TLS 1.3 with no configurable cipher suite
+	// (Go's TLS 1.3 stack doesn't allow picking ciphers). Used for all
+	// calls to the Auth Server.
+	TLSProfileSecure TLSProfile = "secure"
+	// TLSProfileDefault requires TLS 1.2+ with a vetted modern cipher
+	// subset and P-256/P-384 curves only. Used for kube/app/db proxy
+	// connections unless a resource opts into "compat".
+	TLSProfileDefault TLSProfile = "default"
+	// TLSProfileCompat allows TLS 1.2+ with a broader cipher set, for
+	// legacy database/LDAP targets that can't negotiate the default
+	// cipher subset. Only used when a resource explicitly opts in, e.g.
+	// via a db_server annotation.
+	TLSProfileCompat TLSProfile = "compat"
+)
+
+// defaultCipherSuites is the vetted modern cipher subset used by
+// TLSProfileDefault.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// compatCipherSuites additionally allows CBC-mode suites some legacy
+// databases and LDAP servers still require.
+var compatCipherSuites = append(append([]uint16{}, defaultCipherSuites...),
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+)
+
+// Validate checks that profile is one of the known tiers.
+func (p TLSProfile) Validate() error {
+	switch p {
+	case TLSProfileSecure, TLSProfileDefault, TLSProfileCompat:
+		return nil
+	default:
+		return trace.BadParameter("unknown TLS profile %q, expected one of: secure, default, compat", p)
+	}
+}
+
+// baseTLSConfig returns the tls.Config for this profile, ignoring any
+// caller-supplied cipher suite override except under TLSProfileCompat
+// where operators may still need to trim the already-broad default.
+func (p TLSProfile) baseTLSConfig(overrideCipherSuites []uint16) (*tls.Config, error) {
+	if err := p.Validate(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	switch p {
+	case TLSProfileSecure:
+		return &tls.Config{
+			MinVersion: tls.VersionTLS13,
+		}, nil
+	case TLSProfileDefault:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CipherSuites:     defaultCipherSuites,
+			CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+		}, nil
+	case TLSProfileCompat:
+		cipherSuites := compatCipherSuites
+		if len(overrideCipherSuites) > 0 {
+			cipherSuites = overrideCipherSuites
+		}
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			CipherSuites: cipherSuites,
+		}, nil
+	default:
+		// unreachable: Validate already rejected anything else.
+		return nil, trace.BadParameter("unknown TLS profile %q", p)
+	}
+}
+
+// CheckTLSProfileMismatch turns a bare TLS handshake failure into an
+// actionable error when the likely cause is that the server can't satisfy
+// the client's TLS profile (e.g. an auth server still advertising TLS 1.2
+// when the client requires "secure"). handshakeErr is returned unwrapped
+// if it isn't a TLS-layer error (see isTLSHandshakeFailure); any TLS-layer
+// error, not only a version/cipher negotiation failure, gets the hint.
+func CheckTLSProfileMismatch(profile TLSProfile, handshakeErr error) error {
+	if handshakeErr == nil {
+		return nil
+	}
+	if !isTLSHandshakeFailure(handshakeErr) {
+		return handshakeErr
+	}
+	return trace.Wrap(handshakeErr,
+		"TLS handshake failed; this server may not support the %q TLS profile required by this client "+
+			"(try a lower --tls-profile, or upgrade the server)", profile)
+}
+
+func isTLSHandshakeFailure(err error) bool {
+	// tls.RecordHeaderError and the stdlib's unexported alert errors all
+	// stringify with "tls:"; this is a cheap heuristic to classify
+	// handshake failures without depending on internal crypto/tls types.
+	return err != nil && strings.Contains(err.Error(), "tls:")
+}
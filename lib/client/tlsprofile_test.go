@@ -0,0 +1,54 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSProfileValidate(t *testing.T) {
+	for _, p := range []TLSProfile{TLSProfileSecure, TLSProfileDefault, TLSProfileCompat} {
+		require.NoError(t, p.Validate())
+	}
+	require.Error(t, TLSProfile("bogus").Validate())
+}
+
+// TestCheckTLSProfileMismatch exercises CheckTLSProfileMismatch directly.
+// This trimmed checkout has no dial/transport layer yet to surface a real
+// handshake error from, so there's no call site to wire it into; it's kept
+// here as the tested entry point the (currently absent) transport layer
+// will call once it lands.
+func TestCheckTLSProfileMismatch(t *testing.T) {
+	require.NoError(t, CheckTLSProfileMismatch(TLSProfileSecure, nil))
+
+	other := trace.BadParameter("connection refused")
+	require.Equal(t, other, CheckTLSProfileMismatch(TLSProfileSecure, other))
+
+	handshakeErr := trace.Errorf("remote error: tls: protocol version not supported")
+	err := CheckTLSProfileMismatch(TLSProfileSecure, handshakeErr)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"secure" TLS profile`)
+}
+
+func TestIsTLSHandshakeFailure(t *testing.T) {
+	require.True(t, isTLSHandshakeFailure(trace.Errorf("remote error: tls: bad certificate")))
+	require.False(t, isTLSHandshakeFailure(trace.Errorf("connection refused")))
+	require.False(t, isTLSHandshakeFailure(nil))
+}